@@ -0,0 +1,129 @@
+// Package audit 记录每一次经由适配器转发的 OpenAI 兼容请求，
+// 可选落盘到 sqlite/mysql，供 /admin/logs 查询。
+package audit
+
+import (
+	"log"
+	"simple-one-api/pkg/config"
+	"time"
+
+	"gorm.io/driver/mysql"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// RequestLog 对应表 tb_request_third_log，一行记录一次上游调用。
+type RequestLog struct {
+	ID               uint      `gorm:"primaryKey" json:"id"`
+	CreatedAt        time.Time `json:"created_at"`
+	Model            string    `gorm:"index" json:"model"`
+	ServiceName      string    `gorm:"index" json:"service_name"`
+	UpstreamURL      string    `json:"upstream_url"`
+	Stream           bool      `json:"stream"`
+	PromptTokens     int       `json:"prompt_tokens"`
+	CompletionTokens int       `json:"completion_tokens"`
+	OutputLength     int       `json:"output_length"`          // 累计输出字符数，独立于 CompletionTokens 的估算值
+	LatencyMs        int64     `json:"latency_ms"`
+	FirstByteMs      int64     `json:"first_byte_ms"` // 仅流式请求有效
+	LastChunkMs      int64     `json:"last_chunk_ms"` // 仅流式请求有效，流结束（done 事件）时的耗时
+	HTTPStatus       int       `json:"http_status"`
+	ErrorCode        int       `json:"error_code"`
+	ErrorMsg         string    `json:"error_msg"`
+}
+
+var db *gorm.DB
+
+// Init 按 config.Audit() 打开数据库连接并自动建表，Driver 为空时不开启审计。
+func Init() error {
+	auditConf := config.Audit()
+	if auditConf.Driver == "" {
+		return nil
+	}
+
+	var dialector gorm.Dialector
+	switch auditConf.Driver {
+	case "sqlite":
+		dsn := auditConf.DSN
+		if dsn == "" {
+			dsn = "audit.db"
+		}
+		dialector = sqlite.Open(dsn)
+	case "mysql":
+		dialector = mysql.Open(auditConf.DSN)
+	default:
+		log.Printf("audit: 未知的 driver %s，审计日志未开启\n", auditConf.Driver)
+		return nil
+	}
+
+	conn, err := gorm.Open(dialector, &gorm.Config{})
+	if err != nil {
+		return err
+	}
+
+	if err := conn.AutoMigrate(&RequestLog{}); err != nil {
+		return err
+	}
+
+	db = conn
+	log.Println("audit: 审计日志已开启，driver =", auditConf.Driver)
+
+	if auditConf.RetentionDays > 0 {
+		go retentionLoop(auditConf.RetentionDays)
+	}
+
+	return nil
+}
+
+// Enabled 返回审计日志是否已开启。
+func Enabled() bool {
+	return db != nil
+}
+
+// Record 异步写入一条审计记录，db 未初始化时直接忽略。
+func Record(entry RequestLog) {
+	if db == nil {
+		return
+	}
+	go func() {
+		if err := db.Create(&entry).Error; err != nil {
+			log.Println("audit: 写入日志失败:", err)
+		}
+	}()
+}
+
+// Query 用于 /admin/logs，按 model/service_name 过滤并分页返回最近的记录。
+func Query(model, serviceName string, limit, offset int) ([]RequestLog, error) {
+	if db == nil {
+		return nil, nil
+	}
+
+	if limit <= 0 || limit > 500 {
+		limit = 100
+	}
+
+	tx := db.Order("id desc").Limit(limit).Offset(offset)
+	if model != "" {
+		tx = tx.Where("model = ?", model)
+	}
+	if serviceName != "" {
+		tx = tx.Where("service_name = ?", serviceName)
+	}
+
+	var logs []RequestLog
+	if err := tx.Find(&logs).Error; err != nil {
+		return nil, err
+	}
+	return logs, nil
+}
+
+func retentionLoop(retentionDays int) {
+	ticker := time.NewTicker(1 * time.Hour)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		cutoff := time.Now().AddDate(0, 0, -retentionDays)
+		if err := db.Where("created_at < ?", cutoff).Delete(&RequestLog{}).Error; err != nil {
+			log.Println("audit: 清理过期日志失败:", err)
+		}
+	}
+}
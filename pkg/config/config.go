@@ -8,16 +8,113 @@ import (
 	"math/rand"
 	"os"
 	"simple-one-api/pkg/utils"
+	"sync/atomic"
 	"time"
 )
 
 var defaultLimitTimeout int = 10
 
-var ModelToService map[string][]ModelDetails
-var LoadBalancingStrategy string
-var ServerPort string
-var APIKey string
-var Debug bool
+// modelToServicePtr 以 atomic.Pointer 持有当前生效的模型->服务映射，
+// 热加载时整体替换指针，持有旧 *ModelDetails 的调用方不受影响。
+var modelToServicePtr atomic.Pointer[map[string][]ModelDetails]
+
+// runtimeSettings 持有一次配置加载/下发后解析出的全局运行参数（已应用默认值）。
+// 和 modelToServicePtr/lastLoadedConf 一样用 atomic.Pointer 整体替换指针，写者是
+// InitConfig、fsnotify 的 loadAndApply 回调和 /admin/config 的 PUT 处理，读者是任意
+// 请求 goroutine（例如 pickByStrategy 每次负载均衡决策都会读 LoadBalancingStrategy）；
+// 裸的包级变量在这些 goroutine 间并发读写会产生数据竞争。
+type runtimeSettings struct {
+	loadBalancingStrategy   string
+	serverPort              string
+	apiKey                  string
+	adminAPIKey             string
+	debug                   bool
+	audit                   AuditConfig
+	prometheusCollectEnable bool
+	otlpEndpoint            string
+}
+
+var settingsPtr atomic.Pointer[runtimeSettings]
+
+func currentSettings() runtimeSettings {
+	s := settingsPtr.Load()
+	if s == nil {
+		return runtimeSettings{}
+	}
+	return *s
+}
+
+// applyRuntimeSettings 把 conf 合并进当前生效的 runtimeSettings 并整体替换指针：
+// APIKey/AdminAPIKey 仅在非空时覆盖，Debug/Audit/PrometheusCollectEnable/OtlpEndpoint
+// 总是取 conf 中的值（包括零值）。
+// fullReload 为 true 时（InitConfig/loadAndApply，即一次完整的文件加载），
+// LoadBalancingStrategy/ServerPort 在 conf 对应字段为空时回落到默认值；
+// 为 false 时（ApplyConfiguration，/admin/config 的 PUT 属于增量下发），
+// 字段为空表示"不修改"，沿用上一次生效的值，和历史上裸赋值版本的语义保持一致。
+func applyRuntimeSettings(conf Configuration, fullReload bool) {
+	next := currentSettings()
+
+	if conf.LoadBalancing != "" {
+		next.loadBalancingStrategy = conf.LoadBalancing
+	} else if fullReload {
+		next.loadBalancingStrategy = "random"
+	}
+
+	if conf.ServerPort != "" {
+		next.serverPort = conf.ServerPort
+	} else if fullReload {
+		next.serverPort = ":9090"
+	}
+
+	if conf.APIKey != "" {
+		next.apiKey = conf.APIKey
+	}
+	if conf.AdminAPIKey != "" {
+		next.adminAPIKey = conf.AdminAPIKey
+	}
+
+	next.debug = conf.Debug
+	next.audit = conf.Audit
+	next.prometheusCollectEnable = conf.PrometheusCollectEnable
+
+	otlpChanged := next.otlpEndpoint != conf.OtlpEndpoint
+	next.otlpEndpoint = conf.OtlpEndpoint
+
+	settingsPtr.Store(&next)
+
+	if otlpChanged && OnOtlpEndpointChange != nil {
+		OnOtlpEndpointChange(next.otlpEndpoint)
+	}
+}
+
+// LoadBalancingStrategy 返回当前生效的负载均衡策略。
+func LoadBalancingStrategy() string { return currentSettings().loadBalancingStrategy }
+
+// ServerPort 返回当前生效的监听端口。
+func ServerPort() string { return currentSettings().serverPort }
+
+// APIKey 返回客户端聊天接口鉴权用的密钥，留空表示不开启鉴权。
+func APIKey() string { return currentSettings().apiKey }
+
+// AdminAPIKey 返回 /admin 接口鉴权用的密钥，留空时 checkAdminAuth 应 fail closed。
+func AdminAPIKey() string { return currentSettings().adminAPIKey }
+
+// Debug 返回当前是否开启调试日志。
+func Debug() bool { return currentSettings().debug }
+
+// Audit 返回当前生效的审计日志配置。
+func Audit() AuditConfig { return currentSettings().audit }
+
+// PrometheusCollectEnable 返回是否应注册 /metrics 路由。
+func PrometheusCollectEnable() bool { return currentSettings().prometheusCollectEnable }
+
+// OtlpEndpoint 返回当前生效的 OTLP 导出地址，留空表示不开启 tracing 导出。
+func OtlpEndpoint() string { return currentSettings().otlpEndpoint }
+
+// OnOtlpEndpointChange 由 pkg/metrics 在 init() 中注入，在 otlp_endpoint 因为
+// 热加载或 /admin/config 下发而变化时重新构建 OTel TracerProvider。config 包本身
+// 不依赖 metrics，避免循环引用（做法和 BreakerOpenCheck 一致）。
+var OnOtlpEndpointChange func(endpoint string)
 
 type Limit struct {
 	QPS         int `json:"qps"`
@@ -34,6 +131,10 @@ type ServiceModel struct {
 	ServerURL          string            `json:"server_url"`
 	ModelMap           map[string]string `json:"model_map"`
 	Limit              Limit             `json:"limit"`
+	Weight             int               `json:"weight"`         // weighted_random 策略下的相对权重，<=0 时按 1 处理
+	Priority           int               `json:"priority"`       // 数值越小优先级越高，预留给未来按优先级分组的策略
+	ResponseFormat     string            `json:"response_format"` // 空或 "openai"（默认）/ "anthropic"，控制 SSE 事件的帧格式
+	MaxLineSize        int               `json:"max_line_size"`  // 单行 SSE data 的最大字节数，<=0 时使用默认值
 	Limiter            *rate.Limiter     `json:"-"`
 	Timeout            int               `json:"-"`
 	ConcurrencyLimiter chan struct{}     `json:"-"`
@@ -43,8 +144,43 @@ type Configuration struct {
 	ServerPort    string                    `json:"server_port"`
 	Debug         bool                      `json:"debug"`
 	APIKey        string                    `json:"api_key"`
+	AdminAPIKey   string                    `json:"admin_api_key"`
 	LoadBalancing string                    `json:"load_balancing"`
 	Services      map[string][]ServiceModel `json:"services"`
+	Audit         AuditConfig               `json:"audit"`
+
+	PrometheusCollectEnable bool   `json:"prometheus_collect_enable"`
+	OtlpEndpoint            string `json:"otlp_endpoint"`
+}
+
+// RedactConfiguration 返回 conf 的一份拷贝，把每个服务 Credentials 里的值替换成 "***"，
+// 只保留 key 名称。/admin/config 的 GET 响应必须用这份拷贝序列化，避免把 api_key/token
+// 明文吐给任何能访问该接口的调用方。
+func RedactConfiguration(conf Configuration) Configuration {
+	redacted := conf
+	redacted.Services = make(map[string][]ServiceModel, len(conf.Services))
+	for serviceName, models := range conf.Services {
+		redactedModels := make([]ServiceModel, len(models))
+		for i, model := range models {
+			redactedModels[i] = model
+			if model.Credentials != nil {
+				redactedCreds := make(map[string]string, len(model.Credentials))
+				for k := range model.Credentials {
+					redactedCreds[k] = "***"
+				}
+				redactedModels[i].Credentials = redactedCreds
+			}
+		}
+		redacted.Services[serviceName] = redactedModels
+	}
+	return redacted
+}
+
+// AuditConfig 控制请求/响应审计日志的持久化，留空 Driver 表示不开启审计。
+type AuditConfig struct {
+	Driver        string `json:"driver"`         // sqlite / mysql
+	DSN           string `json:"dsn"`            // 数据源地址，sqlite 下可为本地文件路径
+	RetentionDays int    `json:"retention_days"` // 日志保留天数，<=0 表示不自动清理
 }
 
 // ModelDetails 结构用于返回模型相关的服务信息
@@ -53,25 +189,28 @@ type ModelDetails struct {
 	ServiceModel
 }
 
-// 创建模型到服务的映射
-func createModelToServiceMap(config Configuration) map[string][]ModelDetails {
+// 创建模型到服务的映射，prev 为热加载前生效的映射，
+// 如果某个服务的 Limit 配置未发生变化，则复用其 Limiter/ConcurrencyLimiter 实例，
+// 避免丢弃正在排队/占用的请求状态。
+func createModelToServiceMap(config Configuration, prev map[string][]ModelDetails) map[string][]ModelDetails {
 	modelToService := make(map[string][]ModelDetails)
 	for serviceName, serviceModels := range config.Services {
 		for _, model := range serviceModels {
 			if model.Enabled {
-				var limiter *rate.Limiter
-				var semaphore chan struct{}
-				if model.Limit.QPS > 0 {
-					limiter = rate.NewLimiter(rate.Limit(model.Limit.QPS), int(model.Limit.QPS)) // 设定令牌桶的容量等于QPS
-				} else if model.Limit.QPM > 0 {
-					limiter = rate.NewLimiter(rate.Every(1*time.Minute/time.Duration(model.Limit.QPM)), model.Limit.QPM)
-				} else {
-					if model.Limit.Concurrency > 0 {
-						log.Println("create semaphore chan ", model.Limit.Concurrency)
-						semaphore = make(chan struct{}, model.Limit.Concurrency)
-						log.Println(cap(semaphore))
-						for i := 0; i < model.Limit.Concurrency; i++ {
-							semaphore <- struct{}{} // 预填充通道，以便其可以被正确地使用
+				limiter, semaphore := reuseLimiterState(prev, serviceName, model)
+				if limiter == nil && semaphore == nil {
+					if model.Limit.QPS > 0 {
+						limiter = rate.NewLimiter(rate.Limit(model.Limit.QPS), int(model.Limit.QPS)) // 设定令牌桶的容量等于QPS
+					} else if model.Limit.QPM > 0 {
+						limiter = rate.NewLimiter(rate.Every(1*time.Minute/time.Duration(model.Limit.QPM)), model.Limit.QPM)
+					} else {
+						if model.Limit.Concurrency > 0 {
+							log.Println("create semaphore chan ", model.Limit.Concurrency)
+							semaphore = make(chan struct{}, model.Limit.Concurrency)
+							log.Println(cap(semaphore))
+							for i := 0; i < model.Limit.Concurrency; i++ {
+								semaphore <- struct{}{} // 预填充通道，以便其可以被正确地使用
+							}
 						}
 					}
 				}
@@ -99,6 +238,22 @@ func createModelToServiceMap(config Configuration) map[string][]ModelDetails {
 	return modelToService
 }
 
+// reuseLimiterState 在 prev 中查找同一 serviceName 下 Limit 配置相同的条目，
+// 命中时复用其 Limiter/ConcurrencyLimiter，未命中时返回 (nil, nil)。
+func reuseLimiterState(prev map[string][]ModelDetails, serviceName string, model ServiceModel) (*rate.Limiter, chan struct{}) {
+	if prev == nil {
+		return nil, nil
+	}
+	for _, details := range prev {
+		for _, d := range details {
+			if d.ServiceName == serviceName && d.Limit == model.Limit {
+				return d.Limiter, d.ConcurrencyLimiter
+			}
+		}
+	}
+	return nil, nil
+}
+
 // InitConfig 初始化配置
 func InitConfig(configName string) error {
 	if configName == "" {
@@ -128,38 +283,36 @@ func InitConfig(configName string) error {
 		log.Println(err)
 	}
 
-	// 设置负载均衡策略，默认为 "first"
-	if conf.LoadBalancing == "" {
-		LoadBalancingStrategy = "random"
-	} else {
-		LoadBalancingStrategy = conf.LoadBalancing
-	}
-
-	if conf.APIKey != "" {
-		APIKey = conf.APIKey
-	}
-
-	log.Println("read LoadBalancingStrategy ok,", LoadBalancingStrategy)
-
-	// 设置服务器端口，默认为 "9090"
-	if conf.ServerPort == "" {
-		ServerPort = ":9090"
-	} else {
-		ServerPort = conf.ServerPort
-	}
+	applyRuntimeSettings(conf, true)
 
-	Debug = conf.Debug
+	log.Println("read LoadBalancingStrategy ok,", LoadBalancingStrategy())
+	log.Println("read ServerPort ok,", ServerPort())
 
-	log.Println("read ServerPort ok,", ServerPort)
 	// 创建映射
-	ModelToService = createModelToServiceMap(conf)
+	m := createModelToServiceMap(conf, nil)
+	modelToServicePtr.Store(&m)
+	lastLoadedConf.Store(&conf)
 
 	return nil
 }
 
+// modelToService 读取当前生效的模型->服务映射快照。
+func modelToService() map[string][]ModelDetails {
+	m := modelToServicePtr.Load()
+	if m == nil {
+		return nil
+	}
+	return *m
+}
+
+// Snapshot 导出当前生效的模型->服务映射，供 pkg/metrics 这类只读的跨包消费者使用。
+func Snapshot() map[string][]ModelDetails {
+	return modelToService()
+}
+
 // GetAllModelService 根据模型名称获取服务和凭证信息
 func GetAllModelService(modelName string) ([]ModelDetails, error) {
-	if serviceDetails, found := ModelToService[modelName]; found {
+	if serviceDetails, found := modelToService()[modelName]; found {
 		return serviceDetails, nil
 	}
 	return nil, fmt.Errorf("model %s not found in the configuration", modelName)
@@ -167,35 +320,62 @@ func GetAllModelService(modelName string) ([]ModelDetails, error) {
 
 // GetModelService 根据模型名称获取启用的服务和凭证信息
 func GetModelService(modelName string) (*ModelDetails, error) {
-	if serviceDetails, found := ModelToService[modelName]; found {
-		enabledServices := []ModelDetails{}
-		for _, sd := range serviceDetails {
-			if sd.Enabled {
-				enabledServices = append(enabledServices, sd)
-			}
-		}
+	return GetModelServiceForSession(modelName, "")
+}
 
-		if len(enabledServices) == 0 {
-			return nil, fmt.Errorf("no enabled model %s found in the configuration", modelName)
+// GetModelServiceForSession 与 GetModelService 相同，但额外接收一个会话标识
+// （例如 X-SOA-Session 请求头或 ChatCompletionRequest.User），供 sticky 策略做哈希选择。
+// 非 sticky 策略忽略 sessionKey。
+func GetModelServiceForSession(modelName, sessionKey string) (*ModelDetails, error) {
+	serviceDetails, found := modelToService()[modelName]
+	if !found {
+		return nil, fmt.Errorf("model %s not found in the configuration", modelName)
+	}
+
+	enabledServices := make([]ModelDetails, 0, len(serviceDetails))
+	for _, sd := range serviceDetails {
+		if sd.Enabled {
+			enabledServices = append(enabledServices, sd)
 		}
+	}
+
+	if len(enabledServices) == 0 {
+		return nil, fmt.Errorf("no enabled model %s found in the configuration", modelName)
+	}
 
-		switch LoadBalancingStrategy {
-		case "first":
-			return &enabledServices[0], nil
-		case "random":
-			return &enabledServices[rand.Intn(len(enabledServices))], nil
-		default:
-			return &enabledServices[rand.Intn(len(enabledServices))], nil
+	return pickByStrategy(modelName, sessionKey, enabledServices), nil
+}
+
+// PickEnabledModelService 供降级重试路径使用：在已启用的候选中先用 skip 过滤掉
+// 已经尝试过或已熔断的后端，再交给 pickByStrategy 按当前 LoadBalancingStrategy
+// （包括 sticky 的会话哈希）挑一个出来；没有剩余候选时返回 nil。
+func PickEnabledModelService(modelName, sessionKey string, skip func(*ModelDetails) bool) *ModelDetails {
+	serviceDetails, found := modelToService()[modelName]
+	if !found {
+		return nil
+	}
+
+	candidates := make([]ModelDetails, 0, len(serviceDetails))
+	for _, sd := range serviceDetails {
+		sd := sd
+		if !sd.Enabled || skip(&sd) {
+			continue
 		}
+		candidates = append(candidates, sd)
 	}
-	return nil, fmt.Errorf("model %s not found in the configuration", modelName)
+
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	return pickByStrategy(modelName, sessionKey, candidates)
 }
 
 func GetRandomEnabledModelDetails() (*ModelDetails, error) {
 	var enabledModels []ModelDetails
 
-	// 遍历 ModelToService 映射，收集所有 Enabled 为 true 的 ModelDetails
-	for _, models := range ModelToService {
+	// 遍历 modelToService 映射，收集所有 Enabled 为 true 的 ModelDetails
+	for _, models := range modelToService() {
 		for _, model := range models {
 			if model.ServiceModel.Enabled {
 				enabledModels = append(enabledModels, model)
@@ -0,0 +1,215 @@
+package config
+
+import (
+	"hash/fnv"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// pickByStrategy 按 LoadBalancingStrategy 从已启用的候选中选出一个后端，
+// first/random 保留原有行为，其余策略见各自的 pick 函数。
+func pickByStrategy(modelName, sessionKey string, candidates []ModelDetails) *ModelDetails {
+	switch LoadBalancingStrategy() {
+	case "first":
+		return &candidates[0]
+	case "weighted_random":
+		return pickWeightedRandom(candidates)
+	case "round_robin":
+		return pickRoundRobin(modelName, candidates)
+	case "least_conn":
+		return pickLeastConn(candidates)
+	case "least_latency":
+		return pickLeastLatency(candidates)
+	case "sticky":
+		return pickSticky(sessionKey, candidates)
+	case "random":
+		return &candidates[rand.Intn(len(candidates))]
+	default:
+		return &candidates[rand.Intn(len(candidates))]
+	}
+}
+
+// ---- weighted_random：Walker alias method ----
+
+// pickWeightedRandom 用 Walker alias method 按 ServiceModel.Weight 加权抽样，
+// 每次调用重建 alias table；候选数通常很小（同一 model 下的后端数），开销可忽略。
+func pickWeightedRandom(candidates []ModelDetails) *ModelDetails {
+	n := len(candidates)
+	weights := make([]float64, n)
+	var total float64
+	for i, c := range candidates {
+		w := c.Weight
+		if w <= 0 {
+			w = 1
+		}
+		weights[i] = float64(w)
+		total += weights[i]
+	}
+
+	prob := make([]float64, n)
+	alias := make([]int, n)
+	scaled := make([]float64, n)
+	for i, w := range weights {
+		scaled[i] = w * float64(n) / total
+	}
+
+	var small, large []int
+	for i, s := range scaled {
+		if s < 1 {
+			small = append(small, i)
+		} else {
+			large = append(large, i)
+		}
+	}
+
+	for len(small) > 0 && len(large) > 0 {
+		s := small[len(small)-1]
+		small = small[:len(small)-1]
+		l := large[len(large)-1]
+		large = large[:len(large)-1]
+
+		prob[s] = scaled[s]
+		alias[s] = l
+
+		scaled[l] = scaled[l] + scaled[s] - 1
+		if scaled[l] < 1 {
+			small = append(small, l)
+		} else {
+			large = append(large, l)
+		}
+	}
+	for _, l := range large {
+		prob[l] = 1
+	}
+	for _, s := range small {
+		prob[s] = 1
+	}
+
+	i := rand.Intn(n)
+	if rand.Float64() < prob[i] {
+		return &candidates[i]
+	}
+	return &candidates[alias[i]]
+}
+
+// ---- round_robin：按 model name 维护原子计数器 ----
+
+var (
+	roundRobinMu       sync.Mutex
+	roundRobinCounters = map[string]*uint64{}
+)
+
+func pickRoundRobin(modelName string, candidates []ModelDetails) *ModelDetails {
+	roundRobinMu.Lock()
+	counter, ok := roundRobinCounters[modelName]
+	if !ok {
+		counter = new(uint64)
+		roundRobinCounters[modelName] = counter
+	}
+	roundRobinMu.Unlock()
+
+	idx := atomic.AddUint64(counter, 1) - 1
+	return &candidates[int(idx%uint64(len(candidates)))]
+}
+
+// ---- least_conn：选择 ConcurrencyLimiter 当前占用最小的后端 ----
+
+func pickLeastConn(candidates []ModelDetails) *ModelDetails {
+	best := &candidates[0]
+	bestOccupancy := occupancy(best)
+
+	for i := 1; i < len(candidates); i++ {
+		c := &candidates[i]
+		if o := occupancy(c); o < bestOccupancy {
+			best = c
+			bestOccupancy = o
+		}
+	}
+	return best
+}
+
+// occupancy 返回 ConcurrencyLimiter 当前被占用的名额数，没有配置并发限制时视为 0。
+func occupancy(md *ModelDetails) int {
+	if md.ConcurrencyLimiter == nil {
+		return 0
+	}
+	return cap(md.ConcurrencyLimiter) - len(md.ConcurrencyLimiter)
+}
+
+// ---- least_latency：按 ServiceName 维护响应时间的 EWMA ----
+
+const latencyEWMAAlpha = 0.2
+
+var (
+	latencyMu sync.Mutex
+	latencyMs = map[string]float64{}
+)
+
+// RecordLatency 记录一次到某个 ServiceName 的调用耗时，供 least_latency 策略参考，
+// 由 handler 在调用完成后上报（成功或失败都应上报，便于及时感知变慢的后端）。
+func RecordLatency(serviceName string, d time.Duration) {
+	latencyMu.Lock()
+	defer latencyMu.Unlock()
+
+	ms := float64(d.Milliseconds())
+	if cur, ok := latencyMs[serviceName]; ok {
+		latencyMs[serviceName] = latencyEWMAAlpha*ms + (1-latencyEWMAAlpha)*cur
+	} else {
+		latencyMs[serviceName] = ms
+	}
+}
+
+func avgLatency(serviceName string) float64 {
+	latencyMu.Lock()
+	defer latencyMu.Unlock()
+
+	if ms, ok := latencyMs[serviceName]; ok {
+		return ms
+	}
+	return 0
+}
+
+func pickLeastLatency(candidates []ModelDetails) *ModelDetails {
+	best := &candidates[0]
+	bestLatency := avgLatency(best.ServiceName)
+
+	for i := 1; i < len(candidates); i++ {
+		c := &candidates[i]
+		if l := avgLatency(c.ServiceName); l < bestLatency {
+			best = c
+			bestLatency = l
+		}
+	}
+	return best
+}
+
+// ---- sticky：按会话键的 FNV-1a 哈希对候选数取模选后端，目标不可用时退化为下一个健康后端 ----
+// 这是取模哈希而不是一致性哈希环：候选集合增减时，大部分会话键会映射到别的后端。
+
+func pickSticky(sessionKey string, candidates []ModelDetails) *ModelDetails {
+	if sessionKey == "" {
+		return &candidates[rand.Intn(len(candidates))]
+	}
+
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(sessionKey))
+	idx := int(h.Sum32()) % len(candidates)
+	if idx < 0 {
+		idx += len(candidates)
+	}
+
+	// 退化处理：目标后端熔断时，按下标循环顺移到下一个未熔断的候选。
+	for i := 0; i < len(candidates); i++ {
+		c := &candidates[(idx+i)%len(candidates)]
+		if BreakerOpenCheck == nil || !BreakerOpenCheck(c) {
+			return c
+		}
+	}
+	return &candidates[idx]
+}
+
+// BreakerOpenCheck 由 pkg/mw 在 init() 中注入，用于 sticky 策略判断某个候选
+// 的熔断器是否处于 open 状态。config 包本身不依赖 mw，避免循环引用。
+var BreakerOpenCheck func(md *ModelDetails) bool
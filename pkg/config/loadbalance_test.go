@@ -0,0 +1,79 @@
+package config
+
+import "testing"
+
+func candidates(weights ...int) []ModelDetails {
+	out := make([]ModelDetails, len(weights))
+	for i, w := range weights {
+		out[i] = ModelDetails{
+			ServiceName:  svcName(i),
+			ServiceModel: ServiceModel{Weight: w},
+		}
+	}
+	return out
+}
+
+func svcName(i int) string {
+	return string(rune('a' + i))
+}
+
+func TestPickRoundRobinCyclesThroughCandidates(t *testing.T) {
+	cs := candidates(1, 1, 1)
+
+	var got []string
+	for i := 0; i < 6; i++ {
+		got = append(got, pickRoundRobin("round-robin-test-model", cs).ServiceName)
+	}
+
+	want := []string{"a", "b", "c", "a", "b", "c"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("pick %d: got %s, want %s (full sequence %v)", i, got[i], want[i], got)
+		}
+	}
+}
+
+func TestPickStickyIsDeterministicForSameKey(t *testing.T) {
+	cs := candidates(1, 1, 1, 1)
+
+	first := pickSticky("session-42", cs).ServiceName
+	for i := 0; i < 20; i++ {
+		if got := pickSticky("session-42", cs).ServiceName; got != first {
+			t.Fatalf("pickSticky is not deterministic for the same key: got %s, want %s", got, first)
+		}
+	}
+}
+
+func TestPickStickySkipsOpenBreaker(t *testing.T) {
+	cs := candidates(1, 1)
+
+	var blocked string
+	for _, c := range cs {
+		if pickSticky("session-skip", cs).ServiceName == c.ServiceName {
+			blocked = c.ServiceName
+			break
+		}
+	}
+
+	BreakerOpenCheck = func(md *ModelDetails) bool { return md.ServiceName == blocked }
+	defer func() { BreakerOpenCheck = nil }()
+
+	if got := pickSticky("session-skip", cs).ServiceName; got == blocked {
+		t.Fatalf("pickSticky returned the breaker-open candidate %s, expected it to fall back", blocked)
+	}
+}
+
+func TestPickWeightedRandomRespectsWeights(t *testing.T) {
+	cs := candidates(9, 1)
+
+	counts := map[string]int{}
+	const trials = 2000
+	for i := 0; i < trials; i++ {
+		counts[pickWeightedRandom(cs).ServiceName]++
+	}
+
+	heavy, light := counts["a"], counts["b"]
+	if heavy <= light {
+		t.Fatalf("expected the weight-9 candidate to be picked far more often than the weight-1 one, got heavy=%d light=%d", heavy, light)
+	}
+}
@@ -0,0 +1,195 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/fsnotify/fsnotify"
+	"gopkg.in/yaml.v3"
+	"simple-one-api/pkg/utils"
+
+	"github.com/pelletier/go-toml/v2"
+)
+
+// lastLoadedConf 以 atomic.Pointer 持有最近一次成功加载/下发的 Configuration 快照，
+// 写者包括 InitConfig、fsnotify 的 loadAndApply 回调和 /admin/config 的 PUT 处理，
+// 读者是任意请求 goroutine 经由 CurrentConfiguration 访问——和 modelToServicePtr 一样，
+// 整体替换指针而不是就地修改字段，避免裸赋值在这些 goroutine 间产生数据竞争。
+var lastLoadedConf atomic.Pointer[Configuration]
+
+// decodeConfigFile 按扩展名选择 JSON/YAML/TOML 解码器，解析失败时返回错误而不是静默吞掉。
+func decodeConfigFile(path string, data []byte) (Configuration, error) {
+	var conf Configuration
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		if err := yaml.Unmarshal(data, &conf); err != nil {
+			return conf, fmt.Errorf("解析 YAML 配置失败: %v", err)
+		}
+	case ".toml":
+		if err := toml.Unmarshal(data, &conf); err != nil {
+			return conf, fmt.Errorf("解析 TOML 配置失败: %v", err)
+		}
+	default:
+		if err := json.Unmarshal(data, &conf); err != nil {
+			return conf, fmt.Errorf("解析 JSON 配置失败: %v", err)
+		}
+	}
+
+	return conf, nil
+}
+
+// applyEnvOverrides 用形如 SOA_SERVICES_COZECN_0_CREDENTIALS_API_KEY 的环境变量
+// 覆盖 conf.Services[serviceName][index].Credentials[key]。约定环境变量前缀固定为 SOA_SERVICES_。
+const envPrefix = "SOA_SERVICES_"
+
+func applyEnvOverrides(conf *Configuration) {
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, envPrefix) {
+			continue
+		}
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		applyOneEnvOverride(conf, strings.TrimPrefix(parts[0], envPrefix), parts[1])
+	}
+}
+
+// applyOneEnvOverride 解析单条 "<SERVICE>_<INDEX>_CREDENTIALS_<KEY>" 形式的 key。
+func applyOneEnvOverride(conf *Configuration, key, value string) {
+	for serviceName, models := range conf.Services {
+		prefix := strings.ToUpper(serviceName) + "_"
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		rest := strings.SplitN(strings.TrimPrefix(key, prefix), "_", 2)
+		if len(rest) != 2 {
+			continue
+		}
+		idx, err := strconv.Atoi(rest[0])
+		if err != nil || idx < 0 || idx >= len(models) {
+			continue
+		}
+		if !strings.HasPrefix(rest[1], "CREDENTIALS_") {
+			continue
+		}
+		credKey := strings.ToLower(strings.TrimPrefix(rest[1], "CREDENTIALS_"))
+		if models[idx].Credentials == nil {
+			models[idx].Credentials = map[string]string{}
+		}
+		models[idx].Credentials[credKey] = value
+		conf.Services[serviceName] = models
+		return
+	}
+}
+
+// loadAndApply 读取 path、应用环境变量覆盖，并用 reuseLimiterState 保留未变更的限流状态。
+func loadAndApply(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	conf, err := decodeConfigFile(path, data)
+	if err != nil {
+		return err
+	}
+
+	applyEnvOverrides(&conf)
+
+	applyRuntimeSettings(conf, true)
+
+	m := createModelToServiceMap(conf, modelToService())
+	modelToServicePtr.Store(&m)
+	lastLoadedConf.Store(&conf)
+
+	return nil
+}
+
+// WatchConfig 启动 fsnotify 监听，配置文件发生写入/重建时原子性地重建 ModelToService。
+// 只应在 InitConfig 成功之后调用一次；watcher 在进程生命周期内持续运行。
+func WatchConfig(configName string) error {
+	configAbsolutePath, err := utils.ResolveRelativePathToAbsolute(configName)
+	if err != nil {
+		return err
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+
+	if err := watcher.Add(filepath.Dir(configAbsolutePath)); err != nil {
+		watcher.Close()
+		return err
+	}
+
+	go func() {
+		defer watcher.Close()
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if filepath.Clean(event.Name) != filepath.Clean(configAbsolutePath) {
+					continue
+				}
+				if event.Op&(fsnotify.Write|fsnotify.Create) == 0 {
+					continue
+				}
+				log.Println("config: 检测到配置文件变化，重新加载", configAbsolutePath)
+				if err := loadAndApply(configAbsolutePath); err != nil {
+					log.Println("config: 热加载失败:", err)
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Println("config: watcher 错误:", err)
+			}
+		}
+	}()
+
+	return nil
+}
+
+// CurrentConfiguration 返回最近一次成功加载的 Configuration 快照，供 /admin/config 的 GET 使用。
+// 调用方如果要把结果对外暴露（例如写入 HTTP 响应），应先经过 RedactConfiguration 脱敏。
+func CurrentConfiguration() Configuration {
+	c := lastLoadedConf.Load()
+	if c == nil {
+		return Configuration{}
+	}
+	return *c
+}
+
+// ApplyConfiguration 供 /admin/config 的 PUT 使用：校验后立即生效，像热加载一样重建
+// ModelToService；与热加载不同，load_balancing/server_port 为空时沿用上一次生效的值，
+// 不回落到默认值（见 applyRuntimeSettings 的 fullReload 参数）。
+func ApplyConfiguration(conf Configuration) error {
+	if reflect.DeepEqual(conf, Configuration{}) {
+		return fmt.Errorf("empty configuration rejected")
+	}
+	if len(conf.Services) == 0 {
+		return fmt.Errorf("configuration rejected: services 不能为空，会导致所有模型路由被清空")
+	}
+
+	applyEnvOverrides(&conf)
+
+	applyRuntimeSettings(conf, false)
+
+	m := createModelToServiceMap(conf, modelToService())
+	modelToServicePtr.Store(&m)
+	lastLoadedConf.Store(&conf)
+
+	return nil
+}
@@ -0,0 +1,83 @@
+package handler
+
+import (
+	"net/http"
+	"simple-one-api/pkg/audit"
+	"simple-one-api/pkg/config"
+	"simple-one-api/pkg/metrics"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// checkAdminAuth 校验请求是否携带与 config.AdminAPIKey 匹配的 Authorization: Bearer <key>。
+// /admin 下的接口可读写全部服务的 server_url/credentials，不能像客户端聊天接口的
+// config.APIKey 那样在未配置时放行——AdminAPIKey 未配置时一律拒绝（fail closed），
+// 必须显式配置一个独立的管理员密钥才能访问。
+func checkAdminAuth(c *gin.Context) bool {
+	adminAPIKey := config.AdminAPIKey()
+	if adminAPIKey == "" {
+		return false
+	}
+	auth := c.GetHeader("Authorization")
+	return auth == "Bearer "+adminAPIKey
+}
+
+// AdminLogsHandler 提供 GET /admin/logs，支持按 model/service_name 过滤并分页，
+// 审计日志未开启（pkg/audit.Enabled 为 false）时返回空列表。
+func AdminLogsHandler(c *gin.Context) {
+	if !checkAdminAuth(c) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	if !audit.Enabled() {
+		c.JSON(http.StatusOK, gin.H{"logs": []audit.RequestLog{}})
+		return
+	}
+
+	model := c.Query("model")
+	serviceName := c.Query("service_name")
+	limit, _ := strconv.Atoi(c.Query("limit"))
+	offset, _ := strconv.Atoi(c.Query("offset"))
+
+	logs, err := audit.Query(model, serviceName, limit, offset)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"logs": logs})
+}
+
+// AdminConfigHandler 提供 GET/PUT /admin/config，分别用于查看当前生效配置
+// 和不重启进程下发新配置（按 config.ApplyConfiguration 的规则生效）。
+func AdminConfigHandler(c *gin.Context) {
+	if !checkAdminAuth(c) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	switch c.Request.Method {
+	case http.MethodGet:
+		c.JSON(http.StatusOK, config.RedactConfiguration(config.CurrentConfiguration()))
+	case http.MethodPut:
+		var conf config.Configuration
+		if err := c.ShouldBindJSON(&conf); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := config.ApplyConfiguration(conf); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, config.RedactConfiguration(config.CurrentConfiguration()))
+	default:
+		c.JSON(http.StatusMethodNotAllowed, gin.H{"error": "method not allowed"})
+	}
+}
+
+// MetricsHandler 暴露 Prometheus 的 /metrics，仅在 config.PrometheusCollectEnable() 开启时应注册此路由。
+func MetricsHandler(c *gin.Context) {
+	metrics.Handler().ServeHTTP(c.Writer, c.Request)
+}
@@ -4,7 +4,6 @@ import (
 	"bufio"
 	"bytes"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/sashabaranov/go-openai"
@@ -12,16 +11,43 @@ import (
 	"log"
 	"net/http"
 	"simple-one-api/pkg/adapter"
+	"simple-one-api/pkg/audit"
 	"simple-one-api/pkg/config"
 	"simple-one-api/pkg/llm/devplatform/cozecn"
+	"simple-one-api/pkg/metrics"
+	"simple-one-api/pkg/mw"
 	"simple-one-api/pkg/utils"
 	"strings"
+	"time"
 )
 
 var defaultCozecnURL = "https://api.coze.cn/open_api/v2/chat"
 var defaultCozecomURL = "https://api.coze.com/open_api/v2/chat"
 
 func OpenAI2CozecnHandler(c *gin.Context, s *config.ModelDetails, oaiReq openai.ChatCompletionRequest) error {
+	sessionKey := c.GetHeader("X-SOA-Session")
+	if sessionKey == "" {
+		sessionKey = oaiReq.User
+	}
+
+	wg := &mw.WriteGuard{}
+	err := mw.GuardWithFallback(c.Request.Context(), oaiReq.Model, sessionKey, s, wg, func(md *config.ModelDetails) error {
+		return doCozecnRequest(c, md, oaiReq, wg)
+	})
+	if err != nil {
+		if cbErr, ok := err.(*mw.CircuitOpenError); ok {
+			c.Header("Retry-After", fmt.Sprintf("%d", int(cbErr.RetryAfter.Seconds())+1))
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": cbErr.Error()})
+			return err
+		}
+		log.Printf("处理请求失败: %v\n", err)
+		return err
+	}
+
+	return nil
+}
+
+func doCozecnRequest(c *gin.Context, s *config.ModelDetails, oaiReq openai.ChatCompletionRequest, wg *mw.WriteGuard) error {
 	// 使用统一的api_key获取
 	secretToken := s.Credentials[config.KEYNAME_API_KEY]
 	if secretToken == "" {
@@ -44,16 +70,31 @@ func OpenAI2CozecnHandler(c *gin.Context, s *config.ModelDetails, oaiReq openai.
 
 	log.Println(cozeServerURL)
 
-	// 使用统一的错误处理函数
-	if err := sendRequest(c, secretToken, cozeServerURL, cozecnReq, oaiReq); err != nil {
-		log.Printf("处理请求失败: %v\n", err)
-		return err
+	entry := &audit.RequestLog{
+		Model:       oaiReq.Model,
+		ServiceName: s.ServiceName,
+		UpstreamURL: cozeServerURL,
+		Stream:      oaiReq.Stream,
 	}
+	start := time.Now()
+	defer func() {
+		elapsed := time.Since(start)
+		entry.LatencyMs = elapsed.Milliseconds()
+		audit.Record(*entry)
+		config.RecordLatency(s.ServiceName, elapsed)
+		metrics.ObserveRequest(s.ServiceName, oaiReq.Model, fmt.Sprintf("%d", entry.HTTPStatus), oaiReq.Stream, elapsed)
+		metrics.ObserveTokens(s.ServiceName, oaiReq.Model, entry.PromptTokens, entry.CompletionTokens)
+	}()
 
-	return nil
+	// 使用统一的错误处理函数
+	err := sendRequest(c, secretToken, cozeServerURL, cozecnReq, oaiReq, entry, s, wg)
+	if err != nil {
+		entry.ErrorMsg = err.Error()
+	}
+	return err
 }
 
-func sendRequest(c *gin.Context, token, url string, request interface{}, oaiReq openai.ChatCompletionRequest) error {
+func sendRequest(c *gin.Context, token, url string, request interface{}, oaiReq openai.ChatCompletionRequest, entry *audit.RequestLog, s *config.ModelDetails, wg *mw.WriteGuard) error {
 	jsonData, err := json.Marshal(request)
 	if err != nil {
 		return fmt.Errorf("json编码错误: %v", err)
@@ -67,19 +108,27 @@ func sendRequest(c *gin.Context, token, url string, request interface{}, oaiReq
 	req.Header.Set("Authorization", "Bearer "+token)
 	req.Header.Set("Content-Type", "application/json")
 
+	ctx, span := metrics.StartSpan(c.Request.Context(), url)
+	req = req.WithContext(ctx)
+
 	client := &http.Client{}
 	resp, err := client.Do(req)
 	if err != nil {
+		metrics.EndSpan(span, 0, 0, err)
 		return err
 	}
 	defer resp.Body.Close()
 
-	return handleCozecnResponse(c, resp, oaiReq)
+	entry.HTTPStatus = resp.StatusCode
+
+	respErr := handleCozecnResponse(c, resp, oaiReq, entry, s, wg)
+	metrics.EndSpan(span, resp.StatusCode, entry.ErrorCode, respErr)
+	return respErr
 }
 
-func handleCozecnResponse(c *gin.Context, resp *http.Response, oaiReq openai.ChatCompletionRequest) error {
+func handleCozecnResponse(c *gin.Context, resp *http.Response, oaiReq openai.ChatCompletionRequest, entry *audit.RequestLog, s *config.ModelDetails, wg *mw.WriteGuard) error {
 	if oaiReq.Stream {
-		return handleCozecnStreamResponse(c, oaiReq, resp.Body)
+		return handleCozecnStreamResponse(c, oaiReq, resp.Body, entry, s, wg)
 	}
 
 	body, err := io.ReadAll(resp.Body)
@@ -93,68 +142,105 @@ func handleCozecnResponse(c *gin.Context, resp *http.Response, oaiReq openai.Cha
 	}
 
 	if respJson.Code != 0 {
+		entry.ErrorCode = respJson.Code
 		return fmt.Errorf("错误码: %d, 错误信息: %s", respJson.Code, respJson.Msg)
 	}
 
 	myresp := adapter.CozecnReponseToOpenAIResponse(&respJson)
 	myresp.Model = oaiReq.Model
+	entry.PromptTokens = myresp.Usage.PromptTokens
+	entry.CompletionTokens = myresp.Usage.CompletionTokens
 	c.JSON(http.StatusOK, myresp)
 
 	return nil
 }
 
-func handleCozecnStreamResponse(c *gin.Context, oaiReq openai.ChatCompletionRequest, body io.Reader) error {
-	scanner := bufio.NewScanner(body)
+// defaultMaxSSELineSize 是单个 SSE data 行的默认上限，远大于 bufio.Scanner 的 64KB，
+// 避免长回复被截断；可通过 ServiceModel.MaxLineSize 调大。这个上限由 readLimitedLine
+// 主动核算累计字节数来强制执行——bufio.Reader 的初始缓冲区大小本身并不能限制
+// ReadString 能读出多长的一行，它会在内部不断重新分配/追加直到遇到换行或真正的 I/O 错误。
+const defaultMaxSSELineSize = 1 << 20 // 1MB
+
+func handleCozecnStreamResponse(c *gin.Context, oaiReq openai.ChatCompletionRequest, body io.Reader, entry *audit.RequestLog, s *config.ModelDetails, wg *mw.WriteGuard) error {
+	maxLineSize := defaultMaxSSELineSize
+	if s.MaxLineSize > 0 {
+		maxLineSize = s.MaxLineSize
+	}
+	reader := bufio.NewReader(body)
 	utils.SetEventStreamHeaders(c)
 
-	for scanner.Scan() {
-		line := scanner.Text()
-		//log.Println(line)
-		if strings.HasPrefix(line, "data:") {
-			log.Println(line)
-			line = strings.TrimPrefix(line, "data:")
-			var response cozecn.StreamResponse
-			if err := json.Unmarshal([]byte(line), &response); err != nil {
-				log.Println(err)
-				return fmt.Errorf("解析响应数据错误: %v", err)
-			}
-			//log.Println(response)
-			switch response.Event {
-			case "message":
-				if response.Message.Type == "verbose" {
-					continue
-				}
-				oaiRespStream := adapter.CozecnReponseToOpenAIResponseStream(&response)
-				oaiRespStream.Model = oaiReq.Model
-				respData, err := json.Marshal(&oaiRespStream)
-				if err != nil {
-					log.Println(err)
-					return err
-				}
-
-				log.Println(string(respData))
-				_, err = c.Writer.WriteString("data: " + string(respData) + "\n\n")
-				if err != nil {
-					log.Println(err)
-				}
-				c.Writer.(http.Flusher).Flush()
-
-			case "done":
+	for _, msg := range oaiReq.Messages {
+		entry.PromptTokens += estimateTokens(msg.Content)
+	}
 
+	t := &streamTranscoder{
+		c:         c,
+		oaiReq:    oaiReq,
+		entry:     entry,
+		anthropic: s.ResponseFormat == "anthropic",
+		start:     time.Now(),
+		wg:        wg,
+	}
+
+	var dataLines []string
+
+	for {
+		line, readErr := readLimitedLine(reader, maxLineSize)
+		trimmed := strings.TrimRight(line, "\r\n")
+
+		switch {
+		case strings.HasPrefix(trimmed, "data:"):
+			// SSE 允许同一事件内出现多行 data:，需要拼接后再整体反序列化，
+			// 否则消息内容里的换行会被当成下一行独立 JSON 处理而解析失败。
+			dataLines = append(dataLines, strings.TrimPrefix(trimmed, "data:"))
+		case trimmed == "" && len(dataLines) > 0:
+			done, err := t.handleEvent(strings.Join(dataLines, "\n"))
+			dataLines = nil
+			if err != nil {
+				return err
+			}
+			if done {
 				return nil
-			case "error":
-				log.Printf("Chat 错误结束: %s\n", response.ErrorInformation.Msg)
-				return fmt.Errorf("错误码: %d, 错误信息: %s", response.ErrorInformation.Code, response.ErrorInformation.Msg)
-			default:
-				fmt.Printf("未知事件: %s\n", line)
-				return errors.New("message error:" + line)
 			}
 		}
+
+		if readErr != nil {
+			if readErr == io.EOF {
+				break
+			}
+			return fmt.Errorf("读取流式响应数据错误: %v", readErr)
+		}
 	}
 
-	if err := scanner.Err(); err != nil {
-		return fmt.Errorf("读取流式响应数据错误: %v", err)
+	if len(dataLines) > 0 {
+		done, err := t.handleEvent(strings.Join(dataLines, "\n"))
+		if err != nil {
+			return err
+		}
+		if done {
+			return nil
+		}
 	}
 
 	return nil
 }
+
+// readLimitedLine 从 reader 中读出一行（包含结尾的 \n），累计字节数超过 maxLineSize
+// 时主动报错终止，而不是依赖 bufio.Reader 内部缓冲区无限增长。
+func readLimitedLine(reader *bufio.Reader, maxLineSize int) (string, error) {
+	var buf []byte
+	for {
+		frag, err := reader.ReadSlice('\n')
+		buf = append(buf, frag...)
+		if len(buf) > maxLineSize {
+			return "", fmt.Errorf("SSE 单行数据超过最大长度 %d 字节", maxLineSize)
+		}
+		if err == nil {
+			return string(buf), nil
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		return string(buf), err
+	}
+}
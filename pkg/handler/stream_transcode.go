@@ -0,0 +1,211 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"simple-one-api/pkg/adapter"
+	"simple-one-api/pkg/audit"
+	"simple-one-api/pkg/llm/devplatform/cozecn"
+	"simple-one-api/pkg/metrics"
+	"simple-one-api/pkg/mw"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sashabaranov/go-openai"
+)
+
+// streamTranscoder 把一个 Coze SSE 事件转换成客户端期望的帧格式（OpenAI 或 Anthropic），
+// 并顺带维护首/末包延迟、累计输出长度、token 估算等审计/指标状态。
+type streamTranscoder struct {
+	c         *gin.Context
+	oaiReq    openai.ChatCompletionRequest
+	entry     *audit.RequestLog
+	anthropic bool
+	start     time.Time
+	wg        *mw.WriteGuard
+
+	chunkCount       int
+	outputLen        int
+	completionTokens int
+}
+
+// estimateTokens 在没有接入分词器的情况下，按「4 个字符约等于 1 个 token」的经验值
+// 粗略估算文本的 token 数，仅用于审计日志和 soa_tokens_total 指标的展示，不要求精确。
+func estimateTokens(s string) int {
+	if s == "" {
+		return 0
+	}
+	n := utf8.RuneCountInString(s) / 4
+	if n == 0 {
+		n = 1
+	}
+	return n
+}
+
+// verboseFunctionCall 是 Coze verbose 事件里携带的函数调用信息的最小形状，
+// 其余字段按需忽略。
+type verboseFunctionCall struct {
+	Name      string          `json:"name"`
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+// handleEvent 处理一个已拼接完整的 SSE data 负载，返回 (是否应结束流, error)。
+func (t *streamTranscoder) handleEvent(data string) (bool, error) {
+	log.Println(data)
+
+	var response cozecn.StreamResponse
+	if err := json.Unmarshal([]byte(data), &response); err != nil {
+		log.Println(err)
+		return false, fmt.Errorf("解析响应数据错误: %v", err)
+	}
+
+	switch response.Event {
+	case "message":
+		if response.Message.Type == "verbose" {
+			if toolCall, ok := parseVerboseToolCall(response.Message.Content); ok {
+				t.writeToolCallDelta(toolCall)
+			}
+			return false, nil
+		}
+
+		t.trackFirstByte()
+		t.chunkCount++
+		t.outputLen += utf8.RuneCountInString(response.Message.Content)
+		t.completionTokens += estimateTokens(response.Message.Content)
+
+		if t.anthropic {
+			t.writeAnthropicContentDelta(response.Message.Content)
+		} else {
+			t.writeOpenAIMessageDelta(&response)
+		}
+		return false, nil
+
+	case "done":
+		t.entry.OutputLength = t.outputLen
+		t.entry.CompletionTokens = t.completionTokens
+		t.entry.LastChunkMs = time.Since(t.start).Milliseconds()
+		if t.anthropic {
+			t.writeAnthropicMessageStop()
+		}
+		return true, nil
+
+	case "error":
+		t.entry.ErrorCode = response.ErrorInformation.Code
+		log.Printf("Chat 错误结束: %s\n", response.ErrorInformation.Msg)
+		return false, fmt.Errorf("错误码: %d, 错误信息: %s", response.ErrorInformation.Code, response.ErrorInformation.Msg)
+
+	default:
+		fmt.Printf("未知事件: %s\n", data)
+		return false, errors.New("message error:" + data)
+	}
+}
+
+func (t *streamTranscoder) trackFirstByte() {
+	if t.chunkCount != 0 {
+		return
+	}
+	firstByte := time.Since(t.start)
+	t.entry.FirstByteMs = firstByte.Milliseconds()
+	metrics.ObserveFirstByte(t.entry.ServiceName, t.oaiReq.Model, firstByte)
+}
+
+func (t *streamTranscoder) writeOpenAIMessageDelta(response *cozecn.StreamResponse) {
+	oaiRespStream := adapter.CozecnReponseToOpenAIResponseStream(response)
+	oaiRespStream.Model = t.oaiReq.Model
+	respData, err := json.Marshal(&oaiRespStream)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	t.writeSSE("", string(respData))
+}
+
+// writeToolCallDelta 把一次函数调用转换为 OpenAI 的 delta.tool_calls 分片。
+// go-openai 的 ToolCall 类型在不同版本间字段差异较大，这里直接按 OpenAI 线上协议拼 JSON，
+// 避免耦合到某一个具体的 SDK 版本。
+func (t *streamTranscoder) writeToolCallDelta(fc verboseFunctionCall) {
+	chunk := map[string]interface{}{
+		"id":      "",
+		"object":  "chat.completion.chunk",
+		"model":   t.oaiReq.Model,
+		"choices": []map[string]interface{}{
+			{
+				"index": 0,
+				"delta": map[string]interface{}{
+					"tool_calls": []map[string]interface{}{
+						{
+							"index": 0,
+							"type":  "function",
+							"function": map[string]interface{}{
+								"name":      fc.Name,
+								"arguments": string(fc.Arguments),
+							},
+						},
+					},
+				},
+				"finish_reason": nil,
+			},
+		},
+	}
+
+	respData, err := json.Marshal(chunk)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	t.writeSSE("", string(respData))
+}
+
+func (t *streamTranscoder) writeAnthropicContentDelta(content string) {
+	payload := map[string]interface{}{
+		"type":  "content_block_delta",
+		"index": 0,
+		"delta": map[string]string{
+			"type": "text_delta",
+			"text": content,
+		},
+	}
+	respData, err := json.Marshal(payload)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	t.writeSSE("content_block_delta", string(respData))
+}
+
+func (t *streamTranscoder) writeAnthropicMessageStop() {
+	t.writeSSE("message_stop", "{}")
+}
+
+func (t *streamTranscoder) writeSSE(event, data string) {
+	var frame string
+	if event != "" {
+		frame = "event: " + event + "\ndata: " + data + "\n\n"
+	} else {
+		frame = "data: " + data + "\n\n"
+	}
+
+	log.Println(frame)
+	t.wg.MarkStarted()
+	if _, err := t.c.Writer.WriteString(frame); err != nil {
+		log.Println(err)
+		return
+	}
+	t.c.Writer.(http.Flusher).Flush()
+}
+
+// parseVerboseToolCall 尝试把 verbose 事件的 Content 解析成一次函数调用；
+// Content 不是一个函数调用负载（没有 name 字段）时返回 ok=false，调用方应忽略该事件。
+func parseVerboseToolCall(content string) (verboseFunctionCall, bool) {
+	var fc verboseFunctionCall
+	if err := json.Unmarshal([]byte(content), &fc); err != nil || fc.Name == "" {
+		return verboseFunctionCall{}, false
+	}
+	return fc, true
+}
@@ -0,0 +1,58 @@
+package handler
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestReadLimitedLineReturnsWholeLine(t *testing.T) {
+	reader := bufio.NewReaderSize(strings.NewReader("data: hello\n"), 8)
+
+	line, err := readLimitedLine(reader, 1<<20)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if line != "data: hello\n" {
+		t.Fatalf("got %q", line)
+	}
+}
+
+// TestReadLimitedLineSpansMultipleInternalBuffers 确保一行内容超过 bufio.Reader
+// 内部缓冲区大小时仍能被完整拼出来——这正是 bufio.NewReaderSize 靠缓冲区大小限制
+// 行长度会失败的地方，readLimitedLine 必须靠自己累计字节数。
+func TestReadLimitedLineSpansMultipleInternalBuffers(t *testing.T) {
+	long := strings.Repeat("x", 100) + "\n"
+	reader := bufio.NewReaderSize(strings.NewReader(long), 8)
+
+	line, err := readLimitedLine(reader, 1<<20)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if line != long {
+		t.Fatalf("got length %d, want %d", len(line), len(long))
+	}
+}
+
+func TestReadLimitedLineEnforcesMaxSize(t *testing.T) {
+	long := strings.Repeat("x", 100) + "\n"
+	reader := bufio.NewReaderSize(strings.NewReader(long), 8)
+
+	_, err := readLimitedLine(reader, 10)
+	if err == nil {
+		t.Fatal("expected an error once the accumulated line exceeds maxLineSize")
+	}
+}
+
+func TestReadLimitedLinePropagatesEOFOnUnterminatedLine(t *testing.T) {
+	reader := bufio.NewReaderSize(strings.NewReader("no trailing newline"), 8)
+
+	line, err := readLimitedLine(reader, 1<<20)
+	if err != io.EOF {
+		t.Fatalf("expected io.EOF, got %v", err)
+	}
+	if line != "no trailing newline" {
+		t.Fatalf("got %q", line)
+	}
+}
@@ -0,0 +1,173 @@
+// Package metrics 提供按 service_name/model/status/stream 打标的 Prometheus 指标，
+// 以及围绕上游调用的 OpenTelemetry span，覆盖 Coze 及其他适配器 handler。
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"simple-one-api/pkg/config"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	requestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "soa_requests_total",
+		Help: "已处理的上游请求数",
+	}, []string{"service_name", "model", "status", "stream"})
+
+	requestLatency = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "soa_request_duration_seconds",
+		Help:    "上游请求总耗时",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"service_name", "model", "stream"})
+
+	firstByteLatency = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "soa_stream_first_byte_seconds",
+		Help:    "流式响应首个 chunk 的耗时",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"service_name", "model"})
+
+	tokensTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "soa_tokens_total",
+		Help: "按 prompt/completion 统计的 token 用量",
+	}, []string{"service_name", "model", "kind"})
+
+	concurrencyOccupied = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "soa_concurrency_limiter_occupied",
+		Help: "ConcurrencyLimiter 当前被占用的名额数",
+	}, []string{"service_name", "model"})
+)
+
+var tracer trace.Tracer
+
+func init() {
+	tracer = otel.Tracer("simple-one-api")
+
+	// config 包不依赖 metrics（避免循环引用），所以用一个注入的回调在 otlp_endpoint
+	// 因为热加载或 /admin/config 下发而变化时重新构建 TracerProvider，做法和
+	// pkg/mw 给 config.BreakerOpenCheck 赋值一致。
+	config.OnOtlpEndpointChange = func(endpoint string) {
+		if err := InitTracing(endpoint); err != nil {
+			log.Println("metrics: 重新初始化 OTel TracerProvider 失败:", err)
+		}
+	}
+}
+
+// InitTracing 在 endpoint 非空时构建一个真正的 OTLP/HTTP exporter 并注册为全局
+// TracerProvider，之后 StartSpan/EndSpan 产生的 span 才会被真正导出；endpoint 为空
+// 时什么都不做，保留 otel 默认的 no-op TracerProvider。应在 config.InitConfig 成功
+// 之后、开始处理请求之前用 config.OtlpEndpoint() 调用一次；之后 otlp_endpoint 因为
+// 热加载或 /admin/config 下发而变化时，config.OnOtlpEndpointChange 回调会自动重新
+// 调用一次，不需要调用方自己轮询。
+func InitTracing(endpoint string) error {
+	if endpoint == "" {
+		return nil
+	}
+
+	exporter, err := otlptracehttp.New(context.Background(),
+		otlptracehttp.WithEndpoint(endpoint),
+		otlptracehttp.WithInsecure(),
+	)
+	if err != nil {
+		return fmt.Errorf("初始化 OTLP exporter 失败: %v", err)
+	}
+
+	res, err := resource.New(context.Background(),
+		resource.WithAttributes(semconv.ServiceName("simple-one-api")),
+	)
+	if err != nil {
+		return fmt.Errorf("初始化 OTel resource 失败: %v", err)
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(tp)
+	tracer = tp.Tracer("simple-one-api")
+
+	return nil
+}
+
+// Handler 返回 /metrics 的 http.Handler，是否注册由调用方按 config.PrometheusCollectEnable() 决定。
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// ObserveRequest 记录一次上游请求的总耗时与结果状态。
+func ObserveRequest(serviceName, model, status string, stream bool, elapsed time.Duration) {
+	streamLabel := "false"
+	if stream {
+		streamLabel = "true"
+	}
+	requestsTotal.WithLabelValues(serviceName, model, status, streamLabel).Inc()
+	requestLatency.WithLabelValues(serviceName, model, streamLabel).Observe(elapsed.Seconds())
+}
+
+// ObserveFirstByte 记录流式响应首个 chunk 到达的耗时，在 handleCozecnStreamResponse 的扫描循环里调用一次。
+func ObserveFirstByte(serviceName, model string, elapsed time.Duration) {
+	firstByteLatency.WithLabelValues(serviceName, model).Observe(elapsed.Seconds())
+}
+
+// ObserveTokens 记录一次请求的 prompt/completion token 用量。
+func ObserveTokens(serviceName, model string, promptTokens, completionTokens int) {
+	tokensTotal.WithLabelValues(serviceName, model, "prompt").Add(float64(promptTokens))
+	tokensTotal.WithLabelValues(serviceName, model, "completion").Add(float64(completionTokens))
+}
+
+// collectConcurrencyGauges 把 config.Snapshot 里每个后端的 ConcurrencyLimiter 占用情况刷新到 gauge 上。
+// 由一个低频后台 goroutine 周期性调用，避免每次请求都重新计算。
+func collectConcurrencyGauges() {
+	for model, details := range config.Snapshot() {
+		for _, d := range details {
+			if d.ConcurrencyLimiter == nil {
+				continue
+			}
+			occupied := cap(d.ConcurrencyLimiter) - len(d.ConcurrencyLimiter)
+			concurrencyOccupied.WithLabelValues(d.ServiceName, model).Set(float64(occupied))
+		}
+	}
+}
+
+// StartConcurrencyCollector 启动周期性刷新 ConcurrencyLimiter 占用 gauge 的后台任务。
+func StartConcurrencyCollector(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			collectConcurrencyGauges()
+		}
+	}()
+}
+
+// StartSpan 为一次 sendRequest 调用开启 span，携带上游 URL；调用方应在结束时调用 EndSpan。
+func StartSpan(ctx context.Context, upstreamURL string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, "upstream.sendRequest", trace.WithAttributes(
+		attribute.String("upstream.url", upstreamURL),
+	))
+}
+
+// EndSpan 记录 HTTP 状态码和上游业务错误码（如 cozecn.Response.Code），然后结束 span。
+func EndSpan(span trace.Span, httpStatus int, upstreamCode int, err error) {
+	span.SetAttributes(
+		attribute.Int("http.status_code", httpStatus),
+		attribute.Int("upstream.code", upstreamCode),
+	)
+	if err != nil {
+		span.RecordError(err)
+	}
+	span.End()
+}
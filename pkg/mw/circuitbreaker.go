@@ -0,0 +1,114 @@
+package mw
+
+import (
+	"sync"
+	"time"
+)
+
+// 熔断器状态
+const (
+	stateClosed = iota
+	stateOpen
+	stateHalfOpen
+)
+
+var defaultFailureThreshold = 5
+var defaultOpenDuration = 30 * time.Second
+
+// circuitBreaker 针对单个 ServiceName+凭证 维度统计连续失败次数，
+// 连续失败达到阈值后进入 open 状态，在 defaultOpenDuration 内直接拒绝请求。
+type circuitBreaker struct {
+	mu              sync.Mutex
+	state           int
+	consecutiveErrs int
+	openedAt        time.Time
+}
+
+func (cb *circuitBreaker) allow() (bool, time.Duration) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	switch cb.state {
+	case stateClosed:
+		return true, 0
+	case stateHalfOpen:
+		// 已经放出过一个探测请求，在 onSuccess/onFailure 把状态收敛回 closed/open
+		// 之前不再放行第二个，否则同一冷却窗口内的所有并发调用都会被当成探测请求
+		// 同时打到一个仍然故障的后端上
+		return false, 0
+	default: // stateOpen
+		remain := defaultOpenDuration - time.Since(cb.openedAt)
+		if remain <= 0 {
+			// 冷却时间已过，进入半开状态，仅放行当前这一次调用作为探测请求
+			cb.state = stateHalfOpen
+			return true, 0
+		}
+		return false, remain
+	}
+}
+
+// status 只读地返回熔断器当前是否应被视为不可用，不做任何状态流转。
+// IsOpen 这类"挑选候选前看一眼"的调用必须用它而不是 allow()：allow() 在
+// 冷却时间过后会把 Open 推进到 HalfOpen 并消耗掉唯一的探测名额，如果
+// 仅仅是负载均衡过滤候选时就调用了 allow()，真正发起请求的那次 Guard()
+// 调用会撞见 HalfOpen 被拒绝，而熔断器从此再也没有人调用 onSuccess/onFailure
+// 帮它收敛，永久卡在 HalfOpen。state 转换只应该发生在即将真正发起请求的
+// 那一次 allow() 里。
+func (cb *circuitBreaker) status() bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	switch cb.state {
+	case stateClosed:
+		return false
+	case stateHalfOpen:
+		// 探测名额已经被占用，对其余调用方视为暂不可用
+		return true
+	default: // stateOpen
+		return time.Since(cb.openedAt) < defaultOpenDuration
+	}
+}
+
+func (cb *circuitBreaker) onSuccess() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	cb.consecutiveErrs = 0
+	cb.state = stateClosed
+}
+
+func (cb *circuitBreaker) onFailure() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	cb.consecutiveErrs++
+	if cb.state == stateHalfOpen || cb.consecutiveErrs >= defaultFailureThreshold {
+		cb.state = stateOpen
+		cb.openedAt = time.Now()
+	}
+}
+
+var (
+	breakersMu sync.Mutex
+	breakers   = map[string]*circuitBreaker{}
+)
+
+func getBreaker(key string) *circuitBreaker {
+	breakersMu.Lock()
+	defer breakersMu.Unlock()
+
+	cb, ok := breakers[key]
+	if !ok {
+		cb = &circuitBreaker{}
+		breakers[key] = cb
+	}
+	return cb
+}
+
+// IsOpen 返回 key（ServiceName+凭证）对应的熔断器当前是否应视为不可用（冷却期内的
+// open，或探测名额已被占用的 half-open；冷却期已过的 open 视为可用），
+// 用于负载均衡在挑选后备服务前提前跳过已熔断的后端。只读检查，不会触发
+// Open -> HalfOpen 的状态转换，那个转换专属于真正发起请求的 allow() 调用。
+func IsOpen(key string) bool {
+	return getBreaker(key).status()
+}
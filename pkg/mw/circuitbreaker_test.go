@@ -0,0 +1,109 @@
+package mw
+
+import (
+	"context"
+	"simple-one-api/pkg/config"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreakerOpensAfterThreshold(t *testing.T) {
+	cb := &circuitBreaker{}
+	for i := 0; i < defaultFailureThreshold-1; i++ {
+		cb.onFailure()
+		if allow, _ := cb.allow(); !allow {
+			t.Fatalf("breaker opened too early, after %d failures", i+1)
+		}
+	}
+
+	cb.onFailure()
+	if allow, _ := cb.allow(); allow {
+		t.Fatal("breaker should be open once consecutive failures reach the threshold")
+	}
+}
+
+// TestCircuitBreakerHalfOpenAllowsOnlyOneProbe 覆盖 allow() 的并发语义：冷却时间
+// 过后，同一窗口内只应放行一次探测请求，其余并发调用必须被拒绝，直到探测结果
+// 通过 onSuccess/onFailure 收敛状态。
+func TestCircuitBreakerHalfOpenAllowsOnlyOneProbe(t *testing.T) {
+	cb := &circuitBreaker{state: stateOpen, openedAt: time.Now().Add(-defaultOpenDuration)}
+
+	const callers = 20
+	allowed := make([]bool, callers)
+
+	var wg sync.WaitGroup
+	wg.Add(callers)
+	for i := 0; i < callers; i++ {
+		go func(i int) {
+			defer wg.Done()
+			ok, _ := cb.allow()
+			allowed[i] = ok
+		}(i)
+	}
+	wg.Wait()
+
+	var allowedCount int
+	for _, ok := range allowed {
+		if ok {
+			allowedCount++
+		}
+	}
+	if allowedCount != 1 {
+		t.Fatalf("expected exactly 1 probe allowed in the half-open window, got %d", allowedCount)
+	}
+}
+
+func TestCircuitBreakerRecoversOnSuccess(t *testing.T) {
+	cb := &circuitBreaker{state: stateHalfOpen}
+
+	cb.onSuccess()
+
+	if cb.state != stateClosed {
+		t.Fatalf("expected state closed after a successful probe, got %d", cb.state)
+	}
+	if allow, _ := cb.allow(); !allow {
+		t.Fatal("breaker should allow requests again after a successful probe")
+	}
+}
+
+// TestIsOpenDoesNotConsumeTheProbeSlot 覆盖 IsOpen 作为负载均衡"只读过滤"的语义：
+// 冷却时间过后反复调用 IsOpen 不应该把熔断器推进到 HalfOpen，真正发起请求的
+// Guard 调用才应该拿到并消耗那唯一的探测名额。
+func TestIsOpenDoesNotConsumeTheProbeSlot(t *testing.T) {
+	md := &config.ModelDetails{ServiceName: "isopen-probe-test-svc"}
+	key := BreakerKey(md)
+	cb := getBreaker(key)
+
+	cb.mu.Lock()
+	cb.state = stateOpen
+	cb.openedAt = time.Now().Add(-defaultOpenDuration)
+	cb.mu.Unlock()
+
+	for i := 0; i < 5; i++ {
+		if IsOpen(key) {
+			t.Fatalf("IsOpen should report the candidate as available once the cooldown elapsed, iteration %d", i)
+		}
+	}
+
+	if err := Guard(context.Background(), md, func() error { return nil }); err != nil {
+		t.Fatalf("Guard should have let the probe request through after IsOpen's read-only checks, got: %v", err)
+	}
+
+	if cb.state != stateClosed {
+		t.Fatalf("expected the breaker to close after a successful probe, got state %d", cb.state)
+	}
+}
+
+func TestCircuitBreakerReopensOnFailedProbe(t *testing.T) {
+	cb := &circuitBreaker{state: stateHalfOpen}
+
+	cb.onFailure()
+
+	if cb.state != stateOpen {
+		t.Fatalf("expected state open after a failed probe, got %d", cb.state)
+	}
+	if allow, remain := cb.allow(); allow || remain <= 0 {
+		t.Fatalf("breaker should reject and report a new cooldown after a failed probe, got allow=%v remain=%v", allow, remain)
+	}
+}
@@ -0,0 +1,142 @@
+// Package mw 提供跨适配器的中间件能力：限流、并发限制、熔断与降级重试，
+// 所有上游调用（Coze 及其他适配器的 handler）都应通过 Guard 包裹后再发起。
+package mw
+
+import (
+	"context"
+	"fmt"
+	"simple-one-api/pkg/config"
+	"sync/atomic"
+	"time"
+)
+
+func init() {
+	config.BreakerOpenCheck = func(md *config.ModelDetails) bool {
+		return IsOpen(BreakerKey(md))
+	}
+}
+
+// CircuitOpenError 在熔断器处于 open 状态时返回，handler 应将其转换为
+// HTTP 503 并携带 Retry-After 响应头。
+type CircuitOpenError struct {
+	ServiceName string
+	RetryAfter  time.Duration
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("service %s circuit open, retry after %s", e.ServiceName, e.RetryAfter)
+}
+
+// WriteGuard 标记一次请求是否已经开始向客户端写出数据。流式响应一旦写出第一个 chunk，
+// 响应头即已发往客户端，此时再切换到另一个后端重试会产生两段拼接在一起的 SSE 流，
+// 所以 GuardWithFallback 必须在 Started() 为 true 后放弃降级，原样返回错误。
+type WriteGuard struct {
+	started atomic.Bool
+}
+
+// MarkStarted 由调用方在第一次向 http.ResponseWriter 写出响应体时调用。
+func (g *WriteGuard) MarkStarted() {
+	if g == nil {
+		return
+	}
+	g.started.Store(true)
+}
+
+// Started 返回是否已经写出过数据；nil 视为未开始，便于调用方在不关心该保护时传 nil。
+func (g *WriteGuard) Started() bool {
+	return g != nil && g.started.Load()
+}
+
+// BreakerKey 生成熔断器/并发统计使用的维度 key，按 ServiceName+凭证 区分，
+// 避免同一服务下不同账号的故障互相影响。
+func BreakerKey(md *config.ModelDetails) string {
+	cred := md.Credentials[config.KEYNAME_API_KEY]
+	if cred == "" {
+		cred = md.Credentials[config.KEYNAME_TOKEN]
+	}
+	return md.ServiceName + ":" + cred
+}
+
+// Guard 用 ServiceModel 上已有的 Limiter/ConcurrencyLimiter/熔断器包裹一次上游调用：
+// 1) Limiter.Wait 受 Timeout 约束；
+// 2) 如果配置了 ConcurrencyLimiter，则在 Timeout 内获取/释放一个名额；
+// 3) 熔断器 open 时直接返回 CircuitOpenError，不发起调用；
+// 4) fn 返回的 error 会被视为上游失败并计入熔断器的连续失败计数。
+func Guard(ctx context.Context, md *config.ModelDetails, fn func() error) error {
+	key := BreakerKey(md)
+	cb := getBreaker(key)
+
+	allow, retryAfter := cb.allow()
+	if !allow {
+		return &CircuitOpenError{ServiceName: md.ServiceName, RetryAfter: retryAfter}
+	}
+
+	timeout := time.Duration(md.Timeout) * time.Second
+	if timeout <= 0 {
+		timeout = time.Duration(defaultLimitTimeout) * time.Second
+	}
+
+	waitCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	if md.Limiter != nil {
+		if err := md.Limiter.Wait(waitCtx); err != nil {
+			return fmt.Errorf("等待限流令牌超时: %v", err)
+		}
+	}
+
+	if md.ConcurrencyLimiter != nil {
+		select {
+		case <-md.ConcurrencyLimiter:
+			defer func() { md.ConcurrencyLimiter <- struct{}{} }()
+		case <-waitCtx.Done():
+			return fmt.Errorf("等待并发许可超时: %v", waitCtx.Err())
+		}
+	}
+
+	err := fn()
+	if err != nil {
+		cb.onFailure()
+		return err
+	}
+
+	cb.onSuccess()
+	return nil
+}
+
+var defaultLimitTimeout = 10
+
+// GuardWithFallback 在 Guard 基础上增加降级能力：当所选后端熔断 open 或调用失败时，
+// 按 sessionKey（sticky 策略用于一致性哈希，其余策略忽略）在剩余候选中挑选下一个
+// 已启用、未熔断、未尝试过的后端重试。wg 非 nil 时，一旦 wg.Started() 为 true
+// （说明已经开始向客户端写出响应体，典型地是流式响应写出了第一个 chunk），
+// 立即放弃降级并原样返回错误，避免把第二个后端的响应拼接进已经发出的流里。
+func GuardWithFallback(ctx context.Context, modelName, sessionKey string, first *config.ModelDetails, wg *WriteGuard, fn func(md *config.ModelDetails) error) error {
+	tried := map[string]bool{}
+
+	candidate := first
+	for {
+		tried[BreakerKey(candidate)] = true
+
+		err := Guard(ctx, candidate, func() error { return fn(candidate) })
+		if err == nil {
+			return nil
+		}
+
+		if wg.Started() {
+			return err
+		}
+
+		next := pickUntried(modelName, sessionKey, tried)
+		if next == nil {
+			return err
+		}
+		candidate = next
+	}
+}
+
+func pickUntried(modelName, sessionKey string, tried map[string]bool) *config.ModelDetails {
+	return config.PickEnabledModelService(modelName, sessionKey, func(md *config.ModelDetails) bool {
+		return tried[BreakerKey(md)] || IsOpen(BreakerKey(md))
+	})
+}